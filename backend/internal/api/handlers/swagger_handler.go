@@ -9,7 +9,10 @@ import (
 //go:embed openapi.yaml
 var openapiSpec []byte
 
-// SwaggerHandler serves OpenAPI documentation
+//go:embed asyncapi.yaml
+var asyncapiSpec []byte
+
+// SwaggerHandler serves OpenAPI and AsyncAPI documentation
 type SwaggerHandler struct{}
 
 // NewSwaggerHandler creates a new swagger handler
@@ -23,6 +26,14 @@ func (h *SwaggerHandler) ServeSpec(c fiber.Ctx) error {
 	return c.Send(openapiSpec)
 }
 
+// ServeAsyncSpec handles GET /api/docs/asyncapi.yaml, documenting the
+// WebSocket handshake, subscription, and JSON-RPC protocols alongside the
+// REST surface.
+func (h *SwaggerHandler) ServeAsyncSpec(c fiber.Ctx) error {
+	c.Set("Content-Type", "application/yaml")
+	return c.Send(asyncapiSpec)
+}
+
 // ServeUI handles GET /api/docs
 func (h *SwaggerHandler) ServeUI(c fiber.Ctx) error {
 	html := `<!DOCTYPE html>
@@ -37,9 +48,23 @@ func (h *SwaggerHandler) ServeUI(c fiber.Ctx) error {
             margin: 0;
             padding: 0;
         }
+        #docs-nav {
+            padding: 8px 16px;
+            background: #1b1b1b;
+            font: 13px -apple-system, sans-serif;
+        }
+        #docs-nav a {
+            color: #89bf04;
+            text-decoration: none;
+            margin-right: 16px;
+        }
     </style>
 </head>
 <body>
+    <div id="docs-nav">
+        REST: <a href="/api/docs/openapi.yaml">openapi.yaml</a>
+        WebSocket: <a href="/api/docs/asyncapi.yaml">asyncapi.yaml</a>
+    </div>
     <div id="swagger-ui"></div>
     <script src="https://cdn.jsdelivr.net/npm/swagger-ui-dist@5.10.0/swagger-ui-bundle.js"></script>
     <script src="https://cdn.jsdelivr.net/npm/swagger-ui-dist@5.10.0/swagger-ui-standalone-preset.js"></script>
@@ -0,0 +1,684 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v3"
+
+	"github.com/unforced/parachute-backend/internal/acp"
+)
+
+// ErrWSDisconnected is returned by any operation on a connection whose
+// reader goroutine has already exited (remote close, read error, or
+// server shutdown). Once a connection's Done channel fires, no further
+// frames will be delivered to it.
+var ErrWSDisconnected = errors.New("handlers: websocket disconnected")
+
+// WebSocket message types. The protocol loosely mirrors graphql-ws: the
+// client must complete a connection_init handshake before any subscribe
+// frame is accepted.
+const (
+	wsTypeConnectionInit = "connection_init"
+	wsTypeConnectionAck  = "connection_ack"
+	wsTypeSubscribe      = "subscribe"
+	wsTypeUnsubscribe    = "unsubscribe"
+	wsTypeMessageChunk   = "message_chunk"
+	wsTypeToolCall       = "tool_call"
+	wsTypeToolCallUpdate = "tool_call_update"
+	wsTypeError          = "error"
+)
+
+// defaultConnectionInitTimeout is how long a client has to send
+// connection_init before the server closes the socket, unless overridden
+// with WithConnectionInitTimeout.
+const defaultConnectionInitTimeout = 10 * time.Second
+
+// wsCloseUnauthorized is the close code sent when a client fails to
+// complete the connection-init handshake in time, or the handshake
+// callback rejects the payload.
+const wsCloseUnauthorized = 4401
+
+const (
+	// defaultPingInterval is how often the broker sends WebSocket ping
+	// frames to each connection to detect dead clients.
+	defaultPingInterval = 30 * time.Second
+
+	// missedPongLimit is the number of ping intervals a connection may go
+	// without a pong before the broker reaps it.
+	missedPongLimit = 2
+
+	// sendBufferSize bounds each connection's outbound queue. A slow
+	// consumer fills this before it is reaped, instead of stalling
+	// broadcasts to every other subscriber.
+	sendBufferSize = 64
+
+	// sendBufferFullDeadline is how long a connection's outbound queue may
+	// stay full before the broker drops it as a slow consumer.
+	sendBufferFullDeadline = 5 * time.Second
+)
+
+// JSON-RPC 2.0 reserved error codes, per the spec.
+const (
+	rpcErrMethodNotFound = -32601
+	rpcErrInternal       = -32603
+)
+
+// wsEnvelope is the outer frame of every message exchanged over the
+// WebSocket, client -> server and server -> client alike. ID is the
+// client-chosen subscription id; every outbound frame echoes the id of
+// the subscription it belongs to so one connection can multiplex many
+// concurrent subscriptions.
+type wsEnvelope struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// rpcRequest is a client-initiated JSON-RPC 2.0 call layered on top of
+// the same socket used for subscribe/unsubscribe. It is distinguished
+// from a wsEnvelope frame by the presence of the "jsonrpc" field. ID is
+// kept as raw JSON rather than json.Number because the spec allows
+// either a string or numeric id, and real clients send both.
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// wsUserContextKey is the context key an RPCHandlerFunc's ctx carries the
+// connection's auth context under; retrieve it with UserContext.
+type wsUserContextKey struct{}
+
+// UserContext returns the opaque value a ConnectionInitFunc returned for
+// the connection an RPC call arrived on, or nil if no handshake callback
+// is configured.
+func UserContext(ctx context.Context) any {
+	return ctx.Value(wsUserContextKey{})
+}
+
+// RPCHandlerFunc handles a single JSON-RPC 2.0 method invoked over the
+// WebSocket command channel, such as conversation.cancel or
+// tool_call.approve. ctx carries the caller's auth context; retrieve it
+// with UserContext.
+type RPCHandlerFunc func(ctx context.Context, params json.RawMessage) (result any, err error)
+
+// ConnectionInitFunc authenticates a connection's handshake payload
+// (a bearer token, API key, or workspace id) and returns an opaque
+// context value that is attached to every subsequent message on that
+// connection.
+type ConnectionInitFunc func(ctx context.Context, payload json.RawMessage) (userCtx any, err error)
+
+// AuthorizeFunc decides whether a connection, identified by the userCtx
+// its ConnectionInitFunc returned, may receive events for conversationID.
+// It is consulted on every broadcast; subscribers it rejects are skipped
+// rather than torn down, since the same connection may be authorized for
+// other conversations.
+type AuthorizeFunc func(userCtx any, conversationID string) bool
+
+// WebSocketHandlerOption configures a WebSocketHandler at construction
+// time.
+type WebSocketHandlerOption func(*WebSocketHandler)
+
+// WithConnectionInit registers a callback that is invoked with the
+// payload of the client's connection_init frame. Until this option is
+// set, connections are accepted without a handshake so existing
+// deployments keep working.
+func WithConnectionInit(fn ConnectionInitFunc) WebSocketHandlerOption {
+	return func(h *WebSocketHandler) {
+		h.connectionInit = fn
+	}
+}
+
+// WithPingInterval overrides the default 30s keepalive ping interval.
+func WithPingInterval(d time.Duration) WebSocketHandlerOption {
+	return func(h *WebSocketHandler) {
+		h.pingInterval = d
+	}
+}
+
+// WithConnectionInitTimeout overrides the default 10s deadline for a
+// client to send connection_init before the server closes the socket.
+func WithConnectionInitTimeout(d time.Duration) WebSocketHandlerOption {
+	return func(h *WebSocketHandler) {
+		h.connectionInitTimeout = d
+	}
+}
+
+// WithAuthorizer registers fn to gate delivery of broadcast events by the
+// userCtx returned from the connection_init handshake. Until this option
+// is set, every subscriber of a conversation receives every event.
+func WithAuthorizer(fn AuthorizeFunc) WebSocketHandlerOption {
+	return func(h *WebSocketHandler) {
+		h.authorize = fn
+	}
+}
+
+// wsConn wraps a single upgraded socket with a bounded outbound queue and
+// a dedicated writer goroutine, so one slow or stuck client can never
+// stall delivery to everyone else. All writes to conn (pings, control
+// frames, and queued data frames) go through writeMu so they never race.
+type wsConn struct {
+	conn    *websocket.Conn
+	send    chan []byte
+	writeMu sync.Mutex
+
+	lastPong  atomic.Int64 // unix nano of the last pong observed
+	fullSince atomic.Int64 // unix nano the send buffer was first observed full, 0 if not full
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newWSConn(c *websocket.Conn) *wsConn {
+	wc := &wsConn{
+		conn: c,
+		send: make(chan []byte, sendBufferSize),
+		done: make(chan struct{}),
+	}
+	wc.lastPong.Store(time.Now().UnixNano())
+	return wc
+}
+
+// Done returns a channel that is closed exactly once, when the connection
+// is torn down: when its reader goroutine exits, or earlier when a write
+// fails or the broker reaps it. Once it fires, no further frames will be
+// delivered and any in-flight Send returns ErrWSDisconnected.
+func (wc *wsConn) Done() <-chan struct{} {
+	return wc.done
+}
+
+// subscriber is a single conversation subscription held open on a
+// connected socket, identified by the client-chosen subscription id. A
+// connection holds one subscriber per active `subscribe` frame, so it can
+// follow several conversations at once.
+type subscriber struct {
+	wc             *wsConn
+	subID          string
+	conversationID string
+	userCtx        any
+}
+
+// WSStats summarizes the broker's live state, for the health endpoint.
+type WSStats struct {
+	Connections   int
+	Subscriptions int
+	DroppedFrames int64
+}
+
+// WebSocketHandler streams ACP message chunks and tool call events to
+// subscribed clients over WebSocket connections, and dispatches
+// client-initiated JSON-RPC 2.0 calls registered via RegisterRPC over the
+// same socket. It runs a keepalive ping/pong broker that reaps dead or
+// stuck connections so they can't block delivery to everyone else.
+type WebSocketHandler struct {
+	acpClient *acp.ACPClient
+
+	connectionInit        ConnectionInitFunc
+	connectionInitTimeout time.Duration
+	authorize             AuthorizeFunc
+	pingInterval          time.Duration
+
+	mu    sync.RWMutex
+	subs  map[string][]*subscriber // conversation_id -> subscribers
+	conns map[*wsConn]struct{}     // live connections
+
+	rpcMu       sync.RWMutex
+	rpcHandlers map[string]RPCHandlerFunc // method -> handler
+
+	dropped atomic.Int64
+}
+
+// NewWebSocketHandler creates a new WebSocket handler backed by acpClient.
+func NewWebSocketHandler(acpClient *acp.ACPClient, opts ...WebSocketHandlerOption) *WebSocketHandler {
+	h := &WebSocketHandler{
+		acpClient:             acpClient,
+		pingInterval:          defaultPingInterval,
+		connectionInitTimeout: defaultConnectionInitTimeout,
+		subs:                  make(map[string][]*subscriber),
+		conns:                 make(map[*wsConn]struct{}),
+		rpcHandlers:           make(map[string]RPCHandlerFunc),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// RegisterRPC registers fn to handle JSON-RPC 2.0 calls for method, such
+// as "conversation.cancel" or "space.switch", received on any connection.
+// Registering the same method twice replaces the previous handler.
+func (h *WebSocketHandler) RegisterRPC(method string, fn RPCHandlerFunc) {
+	h.rpcMu.Lock()
+	defer h.rpcMu.Unlock()
+	h.rpcHandlers[method] = fn
+}
+
+// HandleUpgrade returns the fiber.Handler that upgrades the connection to
+// a WebSocket and services it for its lifetime.
+func (h *WebSocketHandler) HandleUpgrade() fiber.Handler {
+	return websocket.New(func(c *websocket.Conn) {
+		h.serve(c)
+	})
+}
+
+// serve drives a single connection: the connection_init handshake, the
+// keepalive broker, then the subscribe/unsubscribe loop. A connection may
+// hold any number of concurrent subscriptions, each keyed by the
+// client-chosen id in the `subscribe`/`unsubscribe` frame.
+func (h *WebSocketHandler) serve(c *websocket.Conn) {
+	userCtx, ok := h.awaitConnectionInit(c)
+	if !ok {
+		return
+	}
+
+	wc := newWSConn(c)
+	c.SetPongHandler(func(string) error {
+		wc.lastPong.Store(time.Now().UnixNano())
+		return nil
+	})
+
+	h.registerConn(wc)
+	defer h.deregisterConn(wc)
+	defer h.closeConn(wc)
+
+	go h.writeLoop(wc)
+	go h.pingLoop(wc)
+
+	active := make(map[string]*subscriber) // subscription id -> subscriber
+	defer func() {
+		for _, s := range active {
+			h.unsubscribe(s)
+		}
+	}()
+
+	for {
+		_, raw, err := c.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var peek struct {
+			JSONRPC string `json:"jsonrpc"`
+		}
+		if err := json.Unmarshal(raw, &peek); err != nil {
+			continue
+		}
+		if peek.JSONRPC != "" {
+			// Dispatched off the reader goroutine: this is the same
+			// goroutine whose ReadMessage calls deliver pong frames to
+			// the handler installed in serve, so a slow RPCHandlerFunc
+			// must never block it or pingLoop would reap an otherwise
+			// healthy connection out from under an in-flight call.
+			go h.handleRPC(wc, userCtx, raw)
+			continue
+		}
+
+		var env wsEnvelope
+		if err := json.Unmarshal(raw, &env); err != nil {
+			continue
+		}
+
+		switch env.Type {
+		case wsTypeSubscribe:
+			var payload struct {
+				SessionID      string `json:"session_id"`
+				ConversationID string `json:"conversation_id"`
+			}
+			if err := json.Unmarshal(env.Payload, &payload); err != nil {
+				continue
+			}
+			conversationID := payload.ConversationID
+			if conversationID == "" {
+				conversationID = payload.SessionID
+			}
+			if env.ID == "" || conversationID == "" {
+				continue
+			}
+
+			if existing, ok := active[env.ID]; ok {
+				h.unsubscribe(existing)
+			}
+			s := &subscriber{
+				wc:             wc,
+				subID:          env.ID,
+				conversationID: conversationID,
+				userCtx:        userCtx,
+			}
+			active[env.ID] = s
+			h.subscribe(s)
+
+			if data, err := json.Marshal(wsEnvelope{Type: "subscribed", ID: env.ID}); err == nil {
+				h.enqueue(wc, data)
+			}
+
+		case wsTypeUnsubscribe:
+			if s, ok := active[env.ID]; ok {
+				h.unsubscribe(s)
+				delete(active, env.ID)
+			}
+		}
+	}
+}
+
+// writeLoop drains wc's outbound queue and writes each frame to the
+// socket. It is the only goroutine, besides pingLoop, that writes to
+// wc.conn, and exits once wc is done or a write fails.
+func (h *WebSocketHandler) writeLoop(wc *wsConn) {
+	for {
+		select {
+		case <-wc.done:
+			return
+		case data := <-wc.send:
+			wc.writeMu.Lock()
+			err := wc.conn.WriteMessage(websocket.TextMessage, data)
+			wc.writeMu.Unlock()
+			if err != nil {
+				h.closeConn(wc)
+				return
+			}
+		}
+	}
+}
+
+// pingLoop sends a WebSocket ping on every tick and reaps the connection
+// if it has missed missedPongLimit consecutive pongs.
+func (h *WebSocketHandler) pingLoop(wc *wsConn) {
+	ticker := time.NewTicker(h.pingInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if time.Since(time.Unix(0, wc.lastPong.Load())) > time.Duration(missedPongLimit)*h.pingInterval {
+			h.closeConn(wc)
+			return
+		}
+
+		wc.writeMu.Lock()
+		err := wc.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second))
+		wc.writeMu.Unlock()
+		if err != nil {
+			h.closeConn(wc)
+			return
+		}
+	}
+}
+
+// closeConn tears down wc exactly once: closes Done (unblocking writeLoop
+// and signaling every pending/subsequent Send) and closes the underlying
+// socket, which in turn unblocks the reader loop in serve so its deferred
+// cleanup runs.
+//
+// Deliberately, wc.send itself is never closed. enqueue is called from
+// arbitrary broadcast() goroutines concurrently with closeConn; closing
+// wc.send here would race enqueue's non-blocking send on that same
+// channel and panic with "send on closed channel". Signaling teardown
+// through wc.done, which enqueue checks first, avoids that without
+// requiring enqueue and closeConn to share a lock.
+func (h *WebSocketHandler) closeConn(wc *wsConn) {
+	wc.closeOnce.Do(func() {
+		close(wc.done)
+		_ = wc.conn.Close()
+	})
+}
+
+// enqueue delivers data to wc's outbound queue without blocking. It
+// returns ErrWSDisconnected if wc's reader has already exited. If the
+// queue is full, the frame is dropped; if it stays full for longer than
+// sendBufferFullDeadline, wc is treated as a stuck consumer and reaped.
+func (h *WebSocketHandler) enqueue(wc *wsConn, data []byte) error {
+	select {
+	case <-wc.done:
+		return ErrWSDisconnected
+	default:
+	}
+
+	select {
+	case wc.send <- data:
+		wc.fullSince.Store(0)
+		return nil
+	default:
+	}
+
+	h.dropped.Add(1)
+	now := time.Now()
+	if first := wc.fullSince.Load(); first == 0 {
+		wc.fullSince.Store(now.UnixNano())
+	} else if now.Sub(time.Unix(0, first)) > sendBufferFullDeadline {
+		h.closeConn(wc)
+		return ErrWSDisconnected
+	}
+	return nil
+}
+
+// handleRPC dispatches a single JSON-RPC 2.0 request to its registered
+// handler and writes the response back on the same connection. Unknown
+// methods and handler errors are reported as JSON-RPC error objects
+// rather than closing the connection. Called on its own goroutine (see
+// serve), so a slow or hanging RPCHandlerFunc never stalls the
+// connection's reader loop or delays pong processing for pingLoop;
+// wc.writeMu and enqueue's channel already make concurrent responses
+// from multiple in-flight calls safe.
+func (h *WebSocketHandler) handleRPC(wc *wsConn, userCtx any, raw []byte) {
+	var req rpcRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return
+	}
+
+	h.rpcMu.RLock()
+	fn, ok := h.rpcHandlers[req.Method]
+	h.rpcMu.RUnlock()
+
+	resp := rpcResponse{JSONRPC: "2.0", ID: req.ID}
+	switch {
+	case !ok:
+		resp.Error = &rpcError{Code: rpcErrMethodNotFound, Message: "method not found: " + req.Method}
+	default:
+		ctx := context.WithValue(context.Background(), wsUserContextKey{}, userCtx)
+		result, err := fn(ctx, req.Params)
+		if err != nil {
+			resp.Error = &rpcError{Code: rpcErrInternal, Message: err.Error()}
+		} else if result == nil {
+			// A success response always carries "result"; omitempty
+			// would otherwise drop a nil result entirely.
+			resp.Result = json.RawMessage("null")
+		} else {
+			resp.Result = result
+		}
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return
+	}
+	_ = h.enqueue(wc, data)
+}
+
+func (h *WebSocketHandler) registerConn(wc *wsConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.conns[wc] = struct{}{}
+}
+
+func (h *WebSocketHandler) deregisterConn(wc *wsConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.conns, wc)
+}
+
+// awaitConnectionInit waits for the client's connection_init frame,
+// invokes the registered callback, and replies with connection_ack. If
+// no ConnectionInitFunc is configured, the handshake is skipped and the
+// connection is accepted immediately. Connections that don't send
+// connection_init within h.connectionInitTimeout (10s by default, see
+// WithConnectionInitTimeout) are closed with code 4401.
+func (h *WebSocketHandler) awaitConnectionInit(c *websocket.Conn) (any, bool) {
+	if h.connectionInit == nil {
+		return nil, true
+	}
+
+	type initResult struct {
+		env wsEnvelope
+		err error
+	}
+	resultCh := make(chan initResult, 1)
+	go func() {
+		var env wsEnvelope
+		err := c.ReadJSON(&env)
+		resultCh <- initResult{env, err}
+	}()
+
+	select {
+	case r := <-resultCh:
+		if r.err != nil || r.env.Type != wsTypeConnectionInit {
+			h.closeUnauthorized(c, "expected connection_init")
+			return nil, false
+		}
+		userCtx, err := h.connectionInit(context.Background(), r.env.Payload)
+		if err != nil {
+			h.closeUnauthorized(c, err.Error())
+			return nil, false
+		}
+		if err := c.WriteJSON(wsEnvelope{Type: wsTypeConnectionAck}); err != nil {
+			return nil, false
+		}
+		return userCtx, true
+
+	case <-time.After(h.connectionInitTimeout):
+		h.closeUnauthorized(c, "connection_init timeout")
+		return nil, false
+	}
+}
+
+// closeUnauthorized closes the connection with the graphql-ws-style 4401
+// "unauthorized" close code.
+func (h *WebSocketHandler) closeUnauthorized(c *websocket.Conn, reason string) {
+	msg := websocket.FormatCloseMessage(wsCloseUnauthorized, reason)
+	_ = c.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	_ = c.Close()
+}
+
+func (h *WebSocketHandler) subscribe(s *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subs[s.conversationID] = append(h.subs[s.conversationID], s)
+}
+
+func (h *WebSocketHandler) unsubscribe(s *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	subs := h.subs[s.conversationID]
+	for i, existing := range subs {
+		if existing == s {
+			h.subs[s.conversationID] = append(subs[:i], subs[i+1:]...)
+			break
+		}
+	}
+	if len(h.subs[s.conversationID]) == 0 {
+		delete(h.subs, s.conversationID)
+	}
+}
+
+// broadcast delivers env to every subscriber of conversationID, stamping
+// each copy with that subscriber's own subscription id so the client can
+// route it back to the right subscribe() call. If an AuthorizeFunc is
+// configured (see WithAuthorizer), subscribers it rejects for
+// conversationID are skipped rather than sent the event. Delivery is a
+// non-blocking enqueue onto each connection's outbound queue, so one slow
+// or already-disconnected subscriber never stalls or panics the fan-out
+// to the rest; its error is reported back to the caller once delivery to
+// every subscriber has been attempted.
+func (h *WebSocketHandler) broadcast(conversationID string, env wsEnvelope) error {
+	h.mu.RLock()
+	subs := append([]*subscriber(nil), h.subs[conversationID]...)
+	h.mu.RUnlock()
+
+	var firstErr error
+	for _, s := range subs {
+		if h.authorize != nil && !h.authorize(s.userCtx, conversationID) {
+			continue
+		}
+		out := env
+		out.ID = s.subID
+		data, err := json.Marshal(out)
+		if err != nil {
+			continue
+		}
+		if err := h.enqueue(s.wc, data); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// BroadcastMessageChunk sends a streamed ACP message chunk to every
+// client subscribed to conversationID. It returns ErrWSDisconnected if
+// any subscriber's connection had already gone away.
+func (h *WebSocketHandler) BroadcastMessageChunk(conversationID, chunk string) error {
+	payload, _ := json.Marshal(map[string]any{
+		"conversation_id": conversationID,
+		"chunk":           chunk,
+	})
+	return h.broadcast(conversationID, wsEnvelope{Type: wsTypeMessageChunk, Payload: payload})
+}
+
+// BroadcastToolCall announces a new tool call to every client subscribed
+// to conversationID. It returns ErrWSDisconnected if any subscriber's
+// connection had already gone away.
+func (h *WebSocketHandler) BroadcastToolCall(conversationID, toolCallID, title, kind, status string) error {
+	payload, _ := json.Marshal(map[string]any{
+		"conversation_id": conversationID,
+		"tool_call_id":    toolCallID,
+		"title":           title,
+		"kind":            kind,
+		"status":          status,
+	})
+	return h.broadcast(conversationID, wsEnvelope{Type: wsTypeToolCall, Payload: payload})
+}
+
+// BroadcastToolCallUpdate announces a tool call status change to every
+// client subscribed to conversationID. It returns ErrWSDisconnected if
+// any subscriber's connection had already gone away.
+func (h *WebSocketHandler) BroadcastToolCallUpdate(conversationID, toolCallID, status string) error {
+	payload, _ := json.Marshal(map[string]any{
+		"conversation_id": conversationID,
+		"tool_call_id":    toolCallID,
+		"status":          status,
+	})
+	return h.broadcast(conversationID, wsEnvelope{Type: wsTypeToolCallUpdate, Payload: payload})
+}
+
+// Stats reports the broker's live connection and subscription counts,
+// plus a running total of frames dropped for slow or dead consumers.
+func (h *WebSocketHandler) Stats() WSStats {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	subCount := 0
+	for _, subs := range h.subs {
+		subCount += len(subs)
+	}
+
+	return WSStats{
+		Connections:   len(h.conns),
+		Subscriptions: subCount,
+		DroppedFrames: h.dropped.Load(),
+	}
+}
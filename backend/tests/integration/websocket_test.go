@@ -1,7 +1,10 @@
 package integration
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"net"
 	"strings"
 	"testing"
 	"time"
@@ -60,10 +63,12 @@ func TestWebSocketToolCallBroadcast(t *testing.T) {
 	defer client.Close()
 
 	conversationID := "test-conversation-456"
+	subID := "sub-1"
 	subscribeMsg := map[string]interface{}{
 		"type": "subscribe",
+		"id":   subID,
 		"payload": map[string]interface{}{
-			"session_id": conversationID,
+			"conversation_id": conversationID,
 		},
 	}
 	err = client.WriteJSON(subscribeMsg)
@@ -86,8 +91,9 @@ func TestWebSocketToolCallBroadcast(t *testing.T) {
 	err = client.ReadJSON(&toolCallMsg)
 	require.NoError(t, err, "Failed to read tool call broadcast")
 
-	// Verify tool call structure
+	// Verify tool call structure, routed back to our subscription id
 	assert.Equal(t, "tool_call", toolCallMsg["type"])
+	assert.Equal(t, subID, toolCallMsg["id"])
 	payload, ok := toolCallMsg["payload"].(map[string]interface{})
 	require.True(t, ok)
 	assert.Equal(t, conversationID, payload["conversation_id"])
@@ -120,10 +126,12 @@ func TestWebSocketToolCallUpdate(t *testing.T) {
 	defer client.Close()
 
 	conversationID := "test-conversation-789"
+	subID := "sub-1"
 	subscribeMsg := map[string]interface{}{
 		"type": "subscribe",
+		"id":   subID,
 		"payload": map[string]interface{}{
-			"session_id": conversationID,
+			"conversation_id": conversationID,
 		},
 	}
 	client.WriteJSON(subscribeMsg)
@@ -142,8 +150,9 @@ func TestWebSocketToolCallUpdate(t *testing.T) {
 	err = client.ReadJSON(&updateMsg)
 	require.NoError(t, err)
 
-	// Verify update structure
+	// Verify update structure, routed back to our subscription id
 	assert.Equal(t, "tool_call_update", updateMsg["type"])
+	assert.Equal(t, subID, updateMsg["id"])
 	payload, ok := updateMsg["payload"].(map[string]interface{})
 	require.True(t, ok)
 	assert.Equal(t, conversationID, payload["conversation_id"])
@@ -181,8 +190,9 @@ func TestMultipleWebSocketClients(t *testing.T) {
 		conversationID := fmt.Sprintf("test-conversation-%d", i)
 		subscribeMsg := map[string]interface{}{
 			"type": "subscribe",
+			"id":   "sub-0",
 			"payload": map[string]interface{}{
-				"session_id": conversationID,
+				"conversation_id": conversationID,
 			},
 		}
 		client.WriteJSON(subscribeMsg)
@@ -241,8 +251,9 @@ func TestWebSocketConversationFiltering(t *testing.T) {
 	conversationA := "conversation-a"
 	subscribeMsg := map[string]interface{}{
 		"type": "subscribe",
+		"id":   "sub-a",
 		"payload": map[string]interface{}{
-			"session_id": conversationA,
+			"conversation_id": conversationA,
 		},
 	}
 	client.WriteJSON(subscribeMsg)
@@ -272,24 +283,99 @@ func TestWebSocketConversationFiltering(t *testing.T) {
 	assert.Equal(t, "Message for A", payload["chunk"])
 }
 
-// TestWebSocketReconnection tests handling of client disconnection and reconnection
-func TestWebSocketReconnection(t *testing.T) {
-	t.Skip("WebSocket integration tests require refactoring for Fiber v3 - see Issue #1")
+// TestWebSocketMultiplexedSubscriptions tests that one connection can
+// follow several conversations at once, each tagged with its own
+// client-chosen subscription id.
+func TestWebSocketMultiplexedSubscriptions(t *testing.T) {
 	app, wsHandler, cleanup := setupTestServer(t)
 	defer cleanup()
 
-	// Start server in goroutine to avoid blocking
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
 	go func() {
-		_ = app.Listen(":0")
+		_ = app.Listener(ln)
 	}()
-	time.Sleep(100 * time.Millisecond) // Give server time to start
+	defer app.Shutdown()
 
-	// Get the actual listener address
-	// Note: In Fiber v3, we need a different approach for testing
-	// For now, use a fixed port for testing
-	server := &testServer{addr: "http://localhost:19999"} // Fixed port for testing
+	wsURL := "ws://" + ln.Addr().String() + "/ws"
+	client, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	defer client.Close()
 
-	wsURL := "ws" + strings.TrimPrefix(server.URL(), "http") + "/ws"
+	conversationA := "conversation-multi-a"
+	conversationB := "conversation-multi-b"
+
+	for _, sub := range []struct{ id, conversationID string }{
+		{"sub-a", conversationA},
+		{"sub-b", conversationB},
+	} {
+		err = client.WriteJSON(map[string]interface{}{
+			"type": "subscribe",
+			"id":   sub.id,
+			"payload": map[string]interface{}{
+				"conversation_id": sub.conversationID,
+			},
+		})
+		require.NoError(t, err)
+
+		var response map[string]interface{}
+		client.ReadJSON(&response)
+	}
+
+	wsHandler.BroadcastMessageChunk(conversationB, "Message for B")
+	wsHandler.BroadcastMessageChunk(conversationA, "Message for A")
+
+	seen := map[string]string{}
+	for i := 0; i < 2; i++ {
+		var msg map[string]interface{}
+		client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		require.NoError(t, client.ReadJSON(&msg))
+
+		payload, ok := msg["payload"].(map[string]interface{})
+		require.True(t, ok)
+		seen[msg["id"].(string)] = payload["chunk"].(string)
+	}
+
+	assert.Equal(t, "Message for A", seen["sub-a"])
+	assert.Equal(t, "Message for B", seen["sub-b"])
+
+	// Unsubscribing from one leaves the other live.
+	err = client.WriteJSON(map[string]interface{}{"type": "unsubscribe", "id": "sub-a"})
+	require.NoError(t, err)
+
+	// unsubscribe has no reply frame; wait until the server has processed it.
+	require.Eventually(t, func() bool {
+		return wsHandler.Stats().Subscriptions == 1
+	}, time.Second, 10*time.Millisecond)
+
+	wsHandler.BroadcastMessageChunk(conversationA, "Should not arrive")
+	wsHandler.BroadcastMessageChunk(conversationB, "Still subscribed")
+
+	var msg map[string]interface{}
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	require.NoError(t, client.ReadJSON(&msg))
+	payload, ok := msg["payload"].(map[string]interface{})
+	require.True(t, ok)
+	assert.Equal(t, "sub-b", msg["id"])
+	assert.Equal(t, "Still subscribed", payload["chunk"])
+}
+
+// TestWebSocketReconnection tests that a disconnected subscriber never
+// causes BroadcastMessageChunk to panic, that the only error a broadcast
+// racing the disconnect can return is handlers.ErrWSDisconnected, and that
+// a client reconnecting with the same subscription id resumes cleanly.
+func TestWebSocketReconnection(t *testing.T) {
+	app, wsHandler, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = app.Listener(ln)
+	}()
+	defer app.Shutdown()
+
+	wsURL := "ws://" + ln.Addr().String() + "/ws"
 
 	// Connect first time
 	client1, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
@@ -298,8 +384,9 @@ func TestWebSocketReconnection(t *testing.T) {
 	conversationID := "test-reconnect"
 	subscribeMsg := map[string]interface{}{
 		"type": "subscribe",
+		"id":   "sub-1",
 		"payload": map[string]interface{}{
-			"session_id": conversationID,
+			"conversation_id": conversationID,
 		},
 	}
 	client1.WriteJSON(subscribeMsg)
@@ -310,7 +397,19 @@ func TestWebSocketReconnection(t *testing.T) {
 	// Disconnect
 	client1.Close()
 
-	// Wait a bit
+	// A broadcast racing the server's own detection of the close must
+	// never panic. It returns the disconnect sentinel only if it picked up
+	// the subscriber before cleanup removed it and then found the
+	// connection already closed; a broadcast that queues the frame first,
+	// or that runs after cleanup, returns nil.
+	require.NotPanics(t, func() {
+		err = wsHandler.BroadcastMessageChunk(conversationID, "immediately after close")
+	})
+	if err != nil {
+		assert.ErrorIs(t, err, handlers.ErrWSDisconnected)
+	}
+
+	// Wait for the server's reader goroutine to notice and clean up.
 	time.Sleep(100 * time.Millisecond)
 
 	// Reconnect
@@ -318,14 +417,15 @@ func TestWebSocketReconnection(t *testing.T) {
 	require.NoError(t, err)
 	defer client2.Close()
 
-	// Subscribe again
+	// Subscribe again, with the same subscription id
 	client2.WriteJSON(subscribeMsg)
 	client2.ReadJSON(&response)
 
-	// Broadcast a message
-	wsHandler.BroadcastMessageChunk(conversationID, "After reconnect")
+	// Broadcast a message; the reconnected client should receive it
+	// cleanly with no trace of the stale connection.
+	err = wsHandler.BroadcastMessageChunk(conversationID, "After reconnect")
+	require.NoError(t, err)
 
-	// Should receive the message
 	var msg map[string]interface{}
 	client2.SetReadDeadline(time.Now().Add(2 * time.Second))
 	err = client2.ReadJSON(&msg)
@@ -336,6 +436,299 @@ func TestWebSocketReconnection(t *testing.T) {
 	assert.Equal(t, "After reconnect", payload["chunk"])
 }
 
+// TestWebSocketStats verifies that Stats() reflects live connections and
+// subscriptions as clients connect, subscribe, and disconnect.
+func TestWebSocketStats(t *testing.T) {
+	app, wsHandler, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = app.Listener(ln)
+	}()
+	defer app.Shutdown()
+
+	wsURL := "ws://" + ln.Addr().String() + "/ws"
+
+	client, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+
+	// Give the server a moment to register the connection before asserting.
+	require.Eventually(t, func() bool {
+		return wsHandler.Stats().Connections == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0, wsHandler.Stats().Subscriptions)
+
+	subscribeMsg := map[string]interface{}{
+		"type": "subscribe",
+		"id":   "sub-1",
+		"payload": map[string]interface{}{
+			"conversation_id": "test-stats",
+		},
+	}
+	require.NoError(t, client.WriteJSON(subscribeMsg))
+
+	var response map[string]interface{}
+	require.NoError(t, client.ReadJSON(&response))
+	assert.Equal(t, 1, wsHandler.Stats().Subscriptions)
+
+	client.Close()
+
+	require.Eventually(t, func() bool {
+		return wsHandler.Stats().Connections == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0, wsHandler.Stats().Subscriptions)
+}
+
+// TestWebSocketSlowConsumerDropped verifies that a connection which stops
+// reading gets its outbound queue filled, has frames dropped, and is
+// eventually reaped once its queue has stayed full longer than the
+// broker's stuck-consumer deadline, without ever blocking broadcasts to
+// other subscribers.
+func TestWebSocketSlowConsumerDropped(t *testing.T) {
+	app, wsHandler, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = app.Listener(ln)
+	}()
+	defer app.Shutdown()
+
+	wsURL := "ws://" + ln.Addr().String() + "/ws"
+
+	slow, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	defer slow.Close()
+
+	conversationID := "test-slow-consumer"
+	require.NoError(t, slow.WriteJSON(map[string]interface{}{
+		"type": "subscribe",
+		"id":   "sub-1",
+		"payload": map[string]interface{}{
+			"conversation_id": conversationID,
+		},
+	}))
+	var response map[string]interface{}
+	require.NoError(t, slow.ReadJSON(&response))
+
+	// Never read again: the connection's outbound queue and TCP buffers
+	// will fill, forcing the broker to drop frames and, eventually, reap it.
+	for i := 0; i < 200; i++ {
+		_ = wsHandler.BroadcastMessageChunk(conversationID, fmt.Sprintf("chunk-%d", i))
+	}
+	assert.Greater(t, wsHandler.Stats().DroppedFrames, int64(0))
+
+	require.Eventually(t, func() bool {
+		return wsHandler.Stats().Connections == 0
+	}, 10*time.Second, 100*time.Millisecond, "stuck consumer was never reaped")
+}
+
+// TestWebSocketConnectionInitTimeout verifies that a client which never
+// sends connection_init is closed with code 4401 once
+// WithConnectionInitTimeout elapses.
+func TestWebSocketConnectionInitTimeout(t *testing.T) {
+	app, _, cleanup := setupTestServerWithOptions(t,
+		handlers.WithConnectionInit(func(ctx context.Context, payload json.RawMessage) (any, error) {
+			return nil, nil
+		}),
+		handlers.WithConnectionInitTimeout(150*time.Millisecond),
+	)
+	defer cleanup()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = app.Listener(ln)
+	}()
+	defer app.Shutdown()
+
+	wsURL := "ws://" + ln.Addr().String() + "/ws"
+	client, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	defer client.Close()
+
+	// Deliberately never send connection_init.
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err = client.ReadMessage()
+	require.Error(t, err)
+
+	var closeErr *websocket.CloseError
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, 4401, closeErr.Code)
+}
+
+// TestWebSocketAuthorizerRejectsConversation verifies that WithAuthorizer
+// is actually consulted on every broadcast: a subscriber whose userCtx is
+// rejected for a conversation never receives its events, while a
+// subscription to an authorized conversation on the same connection
+// still does.
+func TestWebSocketAuthorizerRejectsConversation(t *testing.T) {
+	app, wsHandler, cleanup := setupTestServerWithOptions(t,
+		handlers.WithConnectionInit(func(ctx context.Context, payload json.RawMessage) (any, error) {
+			return "guest", nil
+		}),
+		handlers.WithAuthorizer(func(userCtx any, conversationID string) bool {
+			return conversationID != "forbidden-conversation"
+		}),
+	)
+	defer cleanup()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = app.Listener(ln)
+	}()
+	defer app.Shutdown()
+
+	wsURL := "ws://" + ln.Addr().String() + "/ws"
+	client, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	defer client.Close()
+
+	require.NoError(t, client.WriteJSON(map[string]interface{}{
+		"type":    "connection_init",
+		"payload": map[string]interface{}{},
+	}))
+	var ack map[string]interface{}
+	require.NoError(t, client.ReadJSON(&ack))
+	require.Equal(t, "connection_ack", ack["type"])
+
+	for _, sub := range []struct{ id, conversationID string }{
+		{"sub-allowed", "allowed-conversation"},
+		{"sub-forbidden", "forbidden-conversation"},
+	} {
+		require.NoError(t, client.WriteJSON(map[string]interface{}{
+			"type": "subscribe",
+			"id":   sub.id,
+			"payload": map[string]interface{}{
+				"conversation_id": sub.conversationID,
+			},
+		}))
+		var response map[string]interface{}
+		require.NoError(t, client.ReadJSON(&response))
+	}
+
+	require.NoError(t, wsHandler.BroadcastMessageChunk("forbidden-conversation", "should not arrive"))
+	require.NoError(t, wsHandler.BroadcastMessageChunk("allowed-conversation", "should arrive"))
+
+	var msg map[string]interface{}
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	require.NoError(t, client.ReadJSON(&msg))
+
+	assert.Equal(t, "sub-allowed", msg["id"])
+	payload, ok := msg["payload"].(map[string]interface{})
+	require.True(t, ok)
+	assert.Equal(t, "should arrive", payload["chunk"])
+}
+
+// TestWebSocketJSONRPC tests that a registered RPC method is dispatched
+// and its response routed back over the same socket used for
+// subscriptions.
+func TestWebSocketJSONRPC(t *testing.T) {
+	app, wsHandler, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	wsHandler.RegisterRPC("conversation.list", func(ctx context.Context, params json.RawMessage) (any, error) {
+		return map[string]any{"conversations": []string{"conv-1", "conv-2"}}, nil
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = app.Listener(ln)
+	}()
+	defer app.Shutdown()
+
+	wsURL := "ws://" + ln.Addr().String() + "/ws"
+	client, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	defer client.Close()
+
+	err = client.WriteJSON(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  "conversation.list",
+	})
+	require.NoError(t, err)
+
+	var resp map[string]interface{}
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	err = client.ReadJSON(&resp)
+	require.NoError(t, err)
+
+	assert.Equal(t, "2.0", resp["jsonrpc"])
+	assert.Nil(t, resp["error"])
+	result, ok := resp["result"].(map[string]interface{})
+	require.True(t, ok)
+	assert.Len(t, result["conversations"], 2)
+}
+
+// TestWebSocketSlowRPCDoesNotBlockConnection verifies that a long-running
+// RPCHandlerFunc is dispatched off the connection's reader goroutine, so
+// a subscribe sent on the same connection while the call is still in
+// flight is processed immediately rather than waiting behind it.
+func TestWebSocketSlowRPCDoesNotBlockConnection(t *testing.T) {
+	app, wsHandler, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	rpcStarted := make(chan struct{})
+	rpcRelease := make(chan struct{})
+	wsHandler.RegisterRPC("slow.method", func(ctx context.Context, params json.RawMessage) (any, error) {
+		close(rpcStarted)
+		<-rpcRelease
+		return map[string]any{"ok": true}, nil
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		_ = app.Listener(ln)
+	}()
+	defer app.Shutdown()
+
+	wsURL := "ws://" + ln.Addr().String() + "/ws"
+	client, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	require.NoError(t, err)
+	defer client.Close()
+
+	require.NoError(t, client.WriteJSON(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      "call-1",
+		"method":  "slow.method",
+	}))
+
+	select {
+	case <-rpcStarted:
+	case <-time.After(2 * time.Second):
+		t.Fatal("slow.method was never dispatched")
+	}
+
+	require.NoError(t, client.WriteJSON(map[string]interface{}{
+		"type": "subscribe",
+		"id":   "sub-1",
+		"payload": map[string]interface{}{
+			"conversation_id": "test-slow-rpc",
+		},
+	}))
+
+	var subscribed map[string]interface{}
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	require.NoError(t, client.ReadJSON(&subscribed), "subscribe reply was blocked behind the in-flight RPC call")
+	assert.Equal(t, "subscribed", subscribed["type"])
+
+	close(rpcRelease)
+
+	var resp map[string]interface{}
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	require.NoError(t, client.ReadJSON(&resp))
+	result, ok := resp["result"].(map[string]interface{})
+	require.True(t, ok)
+	assert.Equal(t, true, result["ok"])
+}
+
 // setupTestServer creates a test Fiber app with WebSocket handler
 func setupTestServer(t *testing.T) (*fiber.App, *handlers.WebSocketHandler, func()) {
 	// Setup in-memory database
@@ -368,3 +761,23 @@ func setupTestServer(t *testing.T) (*fiber.App, *handlers.WebSocketHandler, func
 
 	return app, wsHandler, cleanup
 }
+
+// setupTestServerWithOptions is setupTestServer but lets the caller
+// configure the WebSocketHandler, e.g. with WithConnectionInit,
+// WithConnectionInitTimeout, or WithAuthorizer.
+func setupTestServerWithOptions(t *testing.T, opts ...handlers.WebSocketHandlerOption) (*fiber.App, *handlers.WebSocketHandler, func()) {
+	db, err := sqlite.NewDatabase(":memory:")
+	require.NoError(t, err)
+
+	var acpClient *acp.ACPClient = nil
+	wsHandler := handlers.NewWebSocketHandler(acpClient, opts...)
+
+	app := fiber.New()
+	app.Get("/ws", wsHandler.HandleUpgrade())
+
+	cleanup := func() {
+		db.Close()
+	}
+
+	return app, wsHandler, cleanup
+}